@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"text/template"
 	"unicode"
@@ -15,8 +20,8 @@ import (
 
 // gotest regular expressions
 const (
-	// === RUN TestAdd
-	gtStartRE = "^=== RUN:? ([a-zA-Z_][^[:space:]]*)"
+	// === RUN   TestAdd
+	gtStartRE = "^=== RUN:?[ \t]+([a-zA-Z_][^[:space:]]*)"
 
 	// --- PASS: TestSub (0.00 seconds)
 	// --- FAIL: TestSubFail (0.00 seconds)
@@ -28,9 +33,9 @@ const (
 	gtSuiteRE = "^(ok|FAIL)[ \t]+([^ \t]+)[ \t]+(\\d+.\\d+)"
 
 	// ?       alipay  [no test files]
-	gtNoFilesRE = "^\\?.*\\[no test files\\]$"
+	gtNoFilesRE = `^\?[ \t]+(\S+)[ \t]+\[no test files\]$`
 	// FAIL    node/config [build failed]
-	gtBuildFailedRE = `^FAIL.*\[(build|setup) failed\]$`
+	gtBuildFailedRE = `^FAIL[ \t]+(\S+)[ \t]+\[(build|setup) failed\]$`
 )
 
 // gocheck regular expressions
@@ -44,11 +49,74 @@ const (
 
 const raceRE = "^WARNING: DATA RACE"
 
+// gobench regular expressions
+const (
+	// BenchmarkFoo-8   	 1000000	      1234 ns/op	      56 B/op	       3 allocs/op
+	gtBenchRE = `^(Benchmark[[:word:]/]+)(-\d+)?\s+(\d+)\s+(\d+(?:\.\d+)?)\s+ns/op(?:\s+(\d+)\s+B/op)?(?:\s+(\d+)\s+allocs/op)?`
+
+	// --- FAIL: BenchmarkFoo-8
+	gtBenchFailRE = `^--- FAIL: (Benchmark[[:word:]/]+)(-\d+)?$`
+
+	// pkg: example.com/pkg
+	gtBenchPkgRE = `^pkg:\s+(\S+)`
+)
+
+// Regular expressions used to dig a source location and short message out
+// of a failed test's captured output: the "file:line: message" preamble
+// t.Error/t.Fatal produce, a panic's goroutine stack trace, and a "-race"
+// data race report.
+var (
+	failureLocRE = regexp.MustCompile(`^\s*([^\s:]+\.go):(\d+):\s?(.*)$`)
+	panicRE      = regexp.MustCompile(`^panic: (.+)$`)
+	goroutineRE  = regexp.MustCompile(`^goroutine \d+ \[running\]:$`)
+	raceBlockRE  = regexp.MustCompile(`^(?:Previous write|Previous read|Write|Read) at 0x[0-9a-f]+ by goroutine \d+:$`)
+	racePrevRE   = regexp.MustCompile(`^Previous (?:write|read) at 0x[0-9a-f]+ by goroutine \d+:$`)
+	frameLocRE   = regexp.MustCompile(`^[ \t]+(\S+\.go):(\d+)(?:\s.*)?$`)
+)
+
 type Test struct {
 	Name, Time, Message string
 	Failed              bool
 	Skipped             bool
 	Error               bool
+
+	// Classname is the xUnit classname rendered for this test. It's
+	// populated just before rendering, once the layout of subtests
+	// (see subtestsMode) is known.
+	Classname string
+
+	// File, Line and Function locate the first relevant frame of a
+	// failure, and FailureMessage holds a short, single-line description
+	// of it, distinct from the full captured Message. They're only set
+	// when Failed is true and a location could be found.
+	File, Line, Function, FailureMessage string
+
+	// ErrorType and ErrorMessage describe a non-test error such as a
+	// package build or setup failure, rendered as <error type="..."
+	// message="...">. Left empty for an ordinary <error/>.
+	ErrorType, ErrorMessage string
+
+	// Parent is set when this test is a subtest (created via t.Run) of
+	// another test, in which case Name holds the full "Parent/Child" path
+	// reported by go test. Root tests leave Parent nil.
+	Parent   *Test
+	Children []*Test
+
+	// Properties holds arbitrary name/value pairs rendered as a
+	// <properties> block on the testcase, used for benchmark metrics.
+	Properties []Property
+
+	// SystemOut and SystemErr hold this test's captured output, split by
+	// whether it looked like an ordinary log line or part of a panic/race
+	// trace. Message is reserved for a short failure summary instead.
+	SystemOut, SystemErr string
+}
+
+// Property is a single <property name="..." value="..."/> entry rendered
+// inside a testcase's <properties> block.
+type Property struct {
+	Name  string
+	Value string
 }
 
 type Suite struct {
@@ -56,11 +124,16 @@ type Suite struct {
 	Time   string
 	Status string
 	Tests  []*Test
+
+	// SystemOut holds output seen outside of any test, such as a
+	// package's pre-suite banner or trailing "ok"/"FAIL" stragglers.
+	SystemOut string
 }
 
 type TestResults struct {
-	Suites []*Suite
-	Multi  bool
+	Suites        []*Suite
+	Multi         bool
+	CaptureOutput bool
 }
 
 func (suite *Suite) NumFailed() int {
@@ -99,59 +172,105 @@ func (suite *Suite) Count() int {
 	return len(suite.Tests)
 }
 
-func ParseGoTest(rd io.Reader, race bool) ([]*Suite, error) {
+// ParseGoTest parses the output of "go test". When buildFailuresAsTests is
+// set, a package that fails to build or set up is reported as a synthetic
+// errored testcase instead of aborting the whole parse; when emitEmpty is
+// set, a package with no test files is reported as an empty testsuite.
+func ParseGoTest(rd io.Reader, race, buildFailuresAsTests, emitEmpty bool) ([]*Suite, error) {
 	findStart := regexp.MustCompile(gtStartRE).FindStringSubmatch
 	findRace := regexp.MustCompile(raceRE).MatchString
 	findEnd := regexp.MustCompile(gtEndRE).FindStringSubmatch
 	findSuite := regexp.MustCompile(gtSuiteRE).FindStringSubmatch
-	isNoFiles := regexp.MustCompile(gtNoFilesRE).MatchString
-	isBuildFailed := regexp.MustCompile(gtBuildFailedRE).MatchString
+	findNoFiles := regexp.MustCompile(gtNoFilesRE).FindStringSubmatch
+	findBuildFailed := regexp.MustCompile(gtBuildFailedRE).FindStringSubmatch
 	isExit := regexp.MustCompile("^exit status -?\\d+").MatchString
+	findBench := regexp.MustCompile(gtBenchRE).FindStringSubmatch
+	findBenchFail := regexp.MustCompile(gtBenchFailRE).FindStringSubmatch
 
 	suites := []*Suite{}
 	var (
-		curTest   *Test
+		// testStack holds the currently open tests, outermost first. A
+		// test beyond the first is a subtest (t.Run) of the one below it.
+		testStack []*Test
 		curSuite  *Suite
 		out       []string
 		foundRace bool
 	)
 
 	// Handles a test that ended with a panic.
-	handlePanic := func() {
-		curTest.Failed = true
-		curTest.Skipped = false
-		curTest.Time = "N/A"
-		curSuite.Tests = append(curSuite.Tests, curTest)
-		curTest = nil
+	handlePanic := func(test *Test) {
+		test.Failed = true
+		test.Skipped = false
+		test.Time = "N/A"
+		if test.Parent == nil {
+			curSuite.Tests = append(curSuite.Tests, test)
+		}
+	}
+
+	// Unwinds any tests left open on the stack, treating them as panics.
+	drainStack := func() {
+		for len(testStack) > 0 {
+			test := testStack[len(testStack)-1]
+			testStack = testStack[:len(testStack)-1]
+			handlePanic(test)
+		}
 	}
 
-	// Appends output to the last test.
+	// Appends output to the last completed test, or to the suite itself
+	// if no test has completed yet (a pre-suite banner).
 	appendError := func() error {
-		if len(out) > 0 && curSuite != nil && len(curSuite.Tests) > 0 {
-			message := strings.Join(out, "\n")
-			if curSuite.Tests[len(curSuite.Tests)-1].Message == "" {
-				curSuite.Tests[len(curSuite.Tests)-1].Message = message
-			} else {
-				curSuite.Tests[len(curSuite.Tests)-1].Message += "\n" + message
+		if len(out) == 0 {
+			return nil
+		}
+		if curSuite != nil && len(curSuite.Tests) > 0 {
+			last := curSuite.Tests[len(curSuite.Tests)-1]
+			if last.Failed {
+				last.File, last.Line, last.Function, last.FailureMessage = extractFailure(out)
+				if last.Message == "" {
+					last.Message = last.FailureMessage
+				}
 			}
+			stdout, stderr := splitOutput(out)
+			appendLines(&last.SystemOut, stdout)
+			appendLines(&last.SystemErr, stderr)
+		} else if curSuite != nil {
+			appendLines(&curSuite.SystemOut, out)
 		}
 		out = []string{}
 		return nil
 	}
 
+	// Appends any output seen so far to the test currently running.
+	attachOutput := func(test *Test) {
+		if len(out) == 0 {
+			return
+		}
+		stdout, stderr := splitOutput(out)
+		appendLines(&test.SystemOut, stdout)
+		appendLines(&test.SystemErr, stderr)
+		out = []string{}
+	}
+
 	scanner := NewScanner(rd)
 	scanner.Split(scanPrintable)
 
 	for lnum := 1; scanner.Scan(); lnum++ {
 		line := scanner.Text()
 
-		// TODO: Only outside a suite/test, report as empty suite?
-		if isNoFiles(line) {
+		if tokens := findNoFiles(line); tokens != nil {
+			if emitEmpty {
+				suites = append(suites, &Suite{Name: tokens[1]})
+			}
 			continue
 		}
 
-		if isBuildFailed(line) {
-			return nil, fmt.Errorf("%d: package build failed: %s", lnum, line)
+		if tokens := findBuildFailed(line); tokens != nil {
+			if !buildFailuresAsTests {
+				return nil, fmt.Errorf("%d: package build failed: %s", lnum, line)
+			}
+			suites = append(suites, buildFailedSuite(tokens[1], out))
+			out = nil
+			continue
 		}
 
 		if curSuite == nil {
@@ -160,15 +279,34 @@ func ParseGoTest(rd io.Reader, race bool) ([]*Suite, error) {
 
 		tokens := findStart(line)
 		if tokens != nil {
-			if curTest != nil {
-				// This occurs when the last test ended with a panic.
-				handlePanic()
-			}
-			if e := appendError(); e != nil {
-				return nil, e
+			name := tokens[1]
+
+			// Find the closest open test name is a subtest of. Parallel
+			// subtests (t.Parallel) interleave their "=== RUN"/"--- PASS"
+			// lines across siblings via unmatched "=== PAUSE"/"=== CONT"
+			// lines, so the parent isn't necessarily the top of the stack:
+			// walk the whole stack, innermost first.
+			var parent *Test
+			for i := len(testStack) - 1; i >= 0; i-- {
+				if strings.HasPrefix(name, testStack[i].Name+"/") {
+					parent = testStack[i]
+					break
+				}
 			}
-			curTest = &Test{
-				Name: tokens[1],
+
+			if parent != nil {
+				attachOutput(parent)
+				test := &Test{Name: name, Parent: parent}
+				parent.Children = append(parent.Children, test)
+				testStack = append(testStack, test)
+			} else {
+				// A start while a test is still open, but not one of its
+				// subtests, means the previous test(s) ended with a panic.
+				drainStack()
+				if e := appendError(); e != nil {
+					return nil, e
+				}
+				testStack = append(testStack, &Test{Name: name})
 			}
 			foundRace = false
 			continue
@@ -178,28 +316,42 @@ func ParseGoTest(rd io.Reader, race bool) ([]*Suite, error) {
 
 		tokens = findEnd(line)
 		if tokens != nil {
-			if curTest == nil {
+			// The ending test isn't necessarily the top of the stack either,
+			// for the same reason as above: a paused sibling may still be
+			// open below it.
+			idx := -1
+			for i := len(testStack) - 1; i >= 0; i-- {
+				if testStack[i].Name == tokens[2] {
+					idx = i
+					break
+				}
+			}
+			if idx < 0 {
 				return nil, fmt.Errorf("%d: orphan end test", lnum)
 			}
-			if tokens[2] != curTest.Name {
-				return nil, fmt.Errorf("%d: name mismatch", lnum)
+			test := testStack[idx]
+			testStack = append(testStack[:idx], testStack[idx+1:]...)
+			test.Failed = (tokens[1] == "FAIL" || foundRace)
+			test.Skipped = (tokens[1] == "SKIP")
+			test.Time = tokens[3]
+			stdout, stderr := splitOutput(out)
+			appendLines(&test.SystemOut, stdout)
+			appendLines(&test.SystemErr, stderr)
+			if test.Failed {
+				test.File, test.Line, test.Function, test.FailureMessage = extractFailure(out)
+				test.Message = test.FailureMessage
+			}
+			subtractChildTime(test)
+			if test.Parent == nil {
+				curSuite.Tests = append(curSuite.Tests, test)
 			}
-			curTest.Failed = (tokens[1] == "FAIL" || foundRace)
-			curTest.Skipped = (tokens[1] == "SKIP")
-			curTest.Time = tokens[3]
-			curTest.Message = strings.Join(out, "\n")
-			curSuite.Tests = append(curSuite.Tests, curTest)
-			curTest = nil
 			out = []string{}
 			continue
 		}
 
 		tokens = findSuite(line)
 		if tokens != nil {
-			if curTest != nil {
-				// This occurs when the last test ended with a panic.
-				handlePanic()
-			}
+			drainStack()
 			if e := appendError(); e != nil {
 				return nil, e
 			}
@@ -214,6 +366,22 @@ func ParseGoTest(rd io.Reader, race bool) ([]*Suite, error) {
 			continue
 		}
 
+		// "go test -bench" results are interleaved with regular test
+		// output rather than bracketed by their own start/end lines.
+		if tokens := findBench(line); tokens != nil {
+			curSuite.Tests = append(curSuite.Tests, newBenchTest(tokens))
+			continue
+		}
+
+		// A benchmark that reports "--- FAIL:" has no parenthesized
+		// timing, so it matches neither gtEndRE nor gtBenchRE above; mark
+		// it failed like any other testcase. A benchmark that panics
+		// without printing that line gets no testcase of its own.
+		if tokens := findBenchFail(line); tokens != nil {
+			curSuite.Tests = append(curSuite.Tests, &Test{Name: tokens[1], Failed: true, Time: "N/A"})
+			continue
+		}
+
 		out = append(out, line)
 	}
 
@@ -224,6 +392,433 @@ func ParseGoTest(rd io.Reader, race bool) ([]*Suite, error) {
 	return suites, nil
 }
 
+// splitOutput buckets a test's captured lines into stdout and stderr:
+// once a panic trace, goroutine dump or race report starts, the rest of
+// the lines (being part of that trace) are treated as stderr.
+func splitOutput(lines []string) (stdout, stderr []string) {
+	inErrBlock := false
+	for _, line := range lines {
+		if !inErrBlock && isErrBlockStart(line) {
+			inErrBlock = true
+		}
+		if inErrBlock {
+			stderr = append(stderr, line)
+		} else {
+			stdout = append(stdout, line)
+		}
+	}
+	return stdout, stderr
+}
+
+func isErrBlockStart(line string) bool {
+	return strings.HasPrefix(line, "panic: ") ||
+		strings.HasPrefix(line, "WARNING: DATA RACE") ||
+		goroutineRE.MatchString(line) ||
+		raceBlockRE.MatchString(strings.TrimSpace(line))
+}
+
+// appendLines joins lines with "\n" and appends the result onto *dst,
+// separating it from any existing content with a single "\n". A no-op
+// when lines is empty.
+func appendLines(dst *string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	message := strings.Join(lines, "\n")
+	if *dst == "" {
+		*dst = message
+	} else {
+		*dst += "\n" + message
+	}
+}
+
+// buildFailedSuite synthesizes a Suite reporting that pkg failed to build
+// or set up, capturing any compiler/setup output collected since the last
+// control line (typically "# pkg" followed by the compiler errors) as the
+// failure's message.
+func buildFailedSuite(pkg string, out []string) *Suite {
+	message := strings.Join(out, "\n")
+	errorMessage := "build failed"
+	for _, line := range out {
+		line := strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "# ") {
+			continue
+		}
+		errorMessage = line
+		break
+	}
+	test := &Test{
+		Name:         "BuildFailed",
+		Error:        true,
+		ErrorType:    "build.failed",
+		ErrorMessage: errorMessage,
+		Message:      message,
+	}
+	return &Suite{Name: pkg, Status: "error", Tests: []*Test{test}}
+}
+
+// subtractChildTime removes the time already accounted for by test's
+// subtests from test's own reported Time, since go test includes subtest
+// time in the parent's duration.
+func subtractChildTime(test *Test) {
+	if len(test.Children) == 0 {
+		return
+	}
+	total, err := strconv.ParseFloat(test.Time, 64)
+	if err != nil {
+		return
+	}
+	for _, child := range test.Children {
+		if childTime, err := strconv.ParseFloat(child.Time, 64); err == nil {
+			total -= childTime
+		}
+	}
+	if total < 0 {
+		total = 0
+	}
+	test.Time = strconv.FormatFloat(total, 'f', -1, 64)
+}
+
+// extractFailure scans a failed test's captured output for a source
+// location and short failure message, to surface as xUnit failure
+// attributes. It tries, in order, a "-race" data race report, a panic's
+// goroutine stack trace, and finally the plain "file:line: message"
+// preamble t.Error/t.Fatal produce.
+func extractFailure(lines []string) (file, line, function, message string) {
+	if f, l, m, ok := findRaceLocation(lines); ok {
+		return f, l, "", m
+	}
+	if f, l, fn, m, ok := findPanicLocation(lines); ok {
+		return f, l, fn, m
+	}
+	for _, raw := range lines {
+		if m := failureLocRE.FindStringSubmatch(raw); m != nil {
+			return m[1], m[2], "", m[3]
+		}
+	}
+	return "", "", "", ""
+}
+
+// findPanicLocation looks for a "panic: ..." line followed by a
+// "goroutine N [running]:" header, and returns the first stack frame past
+// it that isn't inside the runtime or testing packages. Frames of
+// t.Helper() functions are not told apart from other user code.
+func findPanicLocation(lines []string) (file, line, function, message string, ok bool) {
+	for i, raw := range lines {
+		m := panicRE.FindStringSubmatch(raw)
+		if m == nil {
+			continue
+		}
+		message = m[1]
+		for j := i + 1; j < len(lines); j++ {
+			if goroutineRE.MatchString(lines[j]) {
+				function, file, line = firstStackFrame(lines[j+1:])
+				return file, line, function, message, file != ""
+			}
+		}
+		return "", "", "", message, false
+	}
+	return "", "", "", "", false
+}
+
+// findRaceLocation looks for the "Previous write at 0x... by goroutine N:"
+// block a race report prints (the historical access that raced with the
+// current one), preferring it over the "Write/Read at ..." block for the
+// current access that always appears first, and returns the location of
+// its first stack frame. Falls back to the current-access block if no
+// "Previous ..." block is present.
+func findRaceLocation(lines []string) (file, line, message string, ok bool) {
+	if f, l, m, ok := findRaceBlock(lines, racePrevRE); ok {
+		return f, l, m, true
+	}
+	return findRaceBlock(lines, raceBlockRE)
+}
+
+// findRaceBlock looks for the first line matching blockRE and returns the
+// location of its first stack frame.
+func findRaceBlock(lines []string, blockRE *regexp.Regexp) (file, line, message string, ok bool) {
+	for i, raw := range lines {
+		if !blockRE.MatchString(strings.TrimSpace(raw)) {
+			continue
+		}
+		_, f, l := firstStackFrame(lines[i+1:])
+		if f != "" {
+			return f, l, strings.TrimSpace(raw), true
+		}
+	}
+	return "", "", "", false
+}
+
+// firstStackFrame walks a goroutine stack trace and returns the first frame
+// outside the runtime and testing packages. It handles both the tab-indented
+// "func(args)"/"\tfile:line +0x.." frames a panic's goroutine dump prints,
+// and the space-indented equivalent the race detector uses.
+func firstStackFrame(lines []string) (function, file, line string) {
+	var curFunc string
+	for _, raw := range lines {
+		if m := frameLocRE.FindStringSubmatch(raw); m != nil {
+			if strings.HasPrefix(curFunc, "runtime.") || strings.HasPrefix(curFunc, "testing.") || curFunc == "panic" {
+				curFunc = ""
+				continue
+			}
+			return curFunc, m[1], m[2]
+		}
+		if trimmed := strings.TrimSpace(raw); trimmed != "" {
+			curFunc = strings.SplitN(trimmed, "(", 2)[0]
+		}
+	}
+	return "", "", ""
+}
+
+// newBenchTest builds a Test from a regexp match of gtBenchRE, converting
+// the reported ns/op into a Time in seconds and recording iteration count,
+// allocation stats and GOMAXPROCS as properties.
+func newBenchTest(tokens []string) *Test {
+	test := &Test{Name: tokens[1]}
+
+	if ns, err := strconv.ParseFloat(tokens[4], 64); err == nil {
+		test.Time = strconv.FormatFloat(ns/1e9, 'f', -1, 64)
+	}
+
+	test.Properties = append(test.Properties,
+		Property{Name: "iterations", Value: tokens[3]},
+		Property{Name: "ns_per_op", Value: tokens[4]},
+	)
+	if tokens[5] != "" {
+		test.Properties = append(test.Properties, Property{Name: "bytes_per_op", Value: tokens[5]})
+	}
+	if tokens[6] != "" {
+		test.Properties = append(test.Properties, Property{Name: "allocs_per_op", Value: tokens[6]})
+	}
+	if tokens[2] != "" {
+		test.Properties = append(test.Properties, Property{Name: "gomaxprocs", Value: strings.TrimPrefix(tokens[2], "-")})
+	}
+
+	return test
+}
+
+// ParseGoBench parses the output of "go test -bench", returning one Test
+// per benchmark with timing and allocation properties. Unlike ParseGoTest,
+// it understands bench-only output that has no "=== RUN"/"--- PASS"
+// framing around each benchmark.
+func ParseGoBench(rd io.Reader, race bool) ([]*Suite, error) {
+	findBench := regexp.MustCompile(gtBenchRE).FindStringSubmatch
+	findBenchFail := regexp.MustCompile(gtBenchFailRE).FindStringSubmatch
+	findPkg := regexp.MustCompile(gtBenchPkgRE).FindStringSubmatch
+	findSuite := regexp.MustCompile(gtSuiteRE).FindStringSubmatch
+	findRace := regexp.MustCompile(raceRE).MatchString
+	isBuildFailed := regexp.MustCompile(gtBuildFailedRE).MatchString
+
+	suites := []*Suite{}
+	var (
+		curSuite  *Suite
+		curTest   *Test
+		out       []string
+		foundRace bool
+	)
+
+	// Closes a benchmark opened by a "--- FAIL:" line, attaching any
+	// output (such as a panic trace) collected since it started.
+	finishFailed := func() {
+		stdout, stderr := splitOutput(out)
+		curTest.SystemOut = strings.Join(stdout, "\n")
+		curTest.SystemErr = strings.Join(stderr, "\n")
+		curTest.File, curTest.Line, curTest.Function, curTest.FailureMessage = extractFailure(out)
+		curTest.Message = curTest.FailureMessage
+		curSuite.Tests = append(curSuite.Tests, curTest)
+		curTest = nil
+		out = nil
+	}
+
+	scanner := NewScanner(rd)
+	scanner.Split(scanPrintable)
+
+	for lnum := 1; scanner.Scan(); lnum++ {
+		line := scanner.Text()
+
+		if isBuildFailed(line) {
+			return nil, fmt.Errorf("%d: package build failed: %s", lnum, line)
+		}
+
+		if curSuite == nil {
+			curSuite = &Suite{}
+		}
+
+		if tokens := findPkg(line); tokens != nil {
+			curSuite.Name = tokens[1]
+			continue
+		}
+
+		if tokens := findBenchFail(line); tokens != nil {
+			if curTest != nil {
+				finishFailed()
+			}
+			curTest = &Test{Name: tokens[1], Failed: true}
+			out = nil
+			foundRace = false
+			continue
+		}
+
+		foundRace = foundRace || (race && findRace(line))
+
+		if curTest != nil {
+			if line == "FAIL" || line == "PASS" {
+				curTest.Failed = curTest.Failed || foundRace
+				finishFailed()
+				continue
+			}
+			if findBench(line) == nil {
+				out = append(out, line)
+				continue
+			}
+			// The next benchmark's result line closes the failed one.
+			curTest.Failed = curTest.Failed || foundRace
+			finishFailed()
+			foundRace = false
+		}
+
+		if tokens := findBench(line); tokens != nil {
+			test := newBenchTest(tokens)
+			test.Failed = foundRace
+			curSuite.Tests = append(curSuite.Tests, test)
+			foundRace = false
+			continue
+		}
+
+		if tokens := findSuite(line); tokens != nil {
+			curSuite.Name = tokens[2]
+			curSuite.Time = tokens[3]
+			suites = append(suites, curSuite)
+			curSuite = nil
+			continue
+		}
+
+		if line == "FAIL" || line == "PASS" {
+			continue
+		}
+	}
+
+	if curTest != nil {
+		finishFailed()
+	}
+	if curSuite != nil && len(curSuite.Tests) > 0 {
+		suites = append(suites, curSuite)
+	}
+
+	return suites, scanner.Err()
+}
+
+// goTestEvent mirrors one line of the JSON stream produced by "go test -json".
+// See https://golang.org/cmd/test2json for the event format.
+type goTestEvent struct {
+	Action  string
+	Package string
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+// ParseGoTestJSON parses the JSON event stream produced by "go test -json",
+// returning the same []*Suite shape as ParseGoTest.
+func ParseGoTestJSON(rd io.Reader, race bool) ([]*Suite, error) {
+	findRace := regexp.MustCompile(raceRE).MatchString
+
+	var (
+		suites     []*Suite
+		suiteByPkg = map[string]*Suite{}
+		testByKey  = map[string]*Test{}
+	)
+
+	dec := json.NewDecoder(rd)
+	for {
+		var ev goTestEvent
+		err := dec.Decode(&ev)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		suite, ok := suiteByPkg[ev.Package]
+		if !ok {
+			suite = &Suite{Name: ev.Package}
+			suiteByPkg[ev.Package] = suite
+			suites = append(suites, suite)
+		}
+
+		if ev.Test == "" {
+			// Package-level event (e.g. build output, "ok"/"FAIL" summary).
+			continue
+		}
+
+		key := ev.Package + "\x00" + ev.Test
+		test, ok := testByKey[key]
+		if !ok {
+			test = &Test{Name: ev.Test}
+			if i := strings.LastIndex(ev.Test, "/"); i >= 0 {
+				if parent, ok := testByKey[ev.Package+"\x00"+ev.Test[:i]]; ok {
+					test.Parent = parent
+					parent.Children = append(parent.Children, test)
+				}
+			}
+			testByKey[key] = test
+		}
+
+		// appendRoot finalizes test: it splits the raw output accumulated
+		// in test.Message into SystemOut/SystemErr, reduces Message itself
+		// down to a short failure summary, and appends the test to its
+		// suite if it's not a subtest.
+		appendRoot := func() {
+			lines := strings.Split(strings.TrimRight(test.Message, "\n"), "\n")
+			stdout, stderr := splitOutput(lines)
+			test.SystemOut = strings.Join(stdout, "\n")
+			test.SystemErr = strings.Join(stderr, "\n")
+			test.Message = ""
+			if test.Failed {
+				test.File, test.Line, test.Function, test.FailureMessage = extractFailure(lines)
+				test.Message = test.FailureMessage
+			}
+			subtractChildTime(test)
+			if test.Parent == nil {
+				suite.Tests = append(suite.Tests, test)
+			}
+		}
+
+		switch ev.Action {
+		case "run", "pause", "cont":
+			// Bookkeeping only; nothing to record on the Test.
+		case "output":
+			if race && findRace(ev.Output) {
+				test.Failed = true
+			}
+			test.Message += ev.Output
+		case "pass":
+			test.Time = formatElapsed(ev.Elapsed)
+			appendRoot()
+		case "fail":
+			test.Failed = true
+			test.Time = formatElapsed(ev.Elapsed)
+			appendRoot()
+		case "skip":
+			test.Skipped = true
+			test.Time = formatElapsed(ev.Elapsed)
+			appendRoot()
+		case "bench":
+			test.Time = formatElapsed(ev.Elapsed)
+			appendRoot()
+		}
+	}
+
+	return suites, nil
+}
+
+// formatElapsed renders an "Elapsed" field from a go test -json event as the
+// same kind of decimal-seconds string the regex based parsers capture.
+func formatElapsed(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', -1, 64)
+}
+
 func map2arr(m map[string]*Suite) []*Suite {
 	arr := make([]*Suite, 0, len(m))
 	for _, suite := range m {
@@ -340,24 +935,98 @@ func hasFailures(suites []*Suite) bool {
 	return false
 }
 
-var xmlTemplate = template.Must(template.New("xml").Parse(`<?xml version="1.0" encoding="utf-8"?>
+// xmlAttrEscape escapes s for safe inclusion inside an XML attribute value,
+// so a failure message containing '"', '<' or '&' (e.g. from a t.Errorf
+// with %q-quoted values) doesn't produce invalid XML.
+func xmlAttrEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+var xmlTemplate = template.Must(template.New("xml").Funcs(template.FuncMap{"attr": xmlAttrEscape}).Parse(`<?xml version="1.0" encoding="utf-8"?>
 {{if .Multi}}<testsuites>{{end}}
 {{range $suite := .Suites}}  <testsuite name="{{.Name}}" tests="{{.Count}}" errors="{{.NumError}}" failures="{{.NumFailed}}" skip="{{.NumSkipped}}">
-{{range  $test := $suite.Tests}}    <testcase classname="{{$suite.Name}}" name="{{$test.Name}}" time="{{$test.Time}}">
+{{if and $.CaptureOutput $suite.SystemOut}}    <system-out><![CDATA[{{$suite.SystemOut}}]]></system-out>
+{{end}}{{range  $test := $suite.Tests}}    <testcase classname="{{$test.Classname}}" name="{{$test.Name}}" time="{{$test.Time}}">
 {{if $test.Skipped }}      <skipped/> {{end}}
-{{if $test.Error }}      <error/> {{end}}
-{{if $test.Failed }}      <failure type="go.error" message="error">
+{{if $test.Error }}{{if $test.ErrorType}}      <error type="{{$test.ErrorType}}" message="{{$test.ErrorMessage | attr}}">
         <![CDATA[{{$test.Message}}]]>
-      </failure>{{end}}    </testcase>
+      </error>{{else}}      <error/>{{end}} {{end}}
+{{if $test.Properties}}      <properties>
+{{range $prop := $test.Properties}}        <property name="{{$prop.Name}}" value="{{$prop.Value | attr}}"/>
+{{end}}      </properties>
+{{end}}{{if $test.Failed }}      <failure type="go.error" message="{{if $test.FailureMessage}}{{$test.FailureMessage | attr}}{{else}}error{{end}}"{{if $test.File}} file="{{$test.File}}"{{end}}{{if $test.Line}} line="{{$test.Line}}"{{end}}>
+        <![CDATA[{{if $test.SystemErr}}{{$test.SystemErr}}{{else if $test.SystemOut}}{{$test.SystemOut}}{{else}}{{$test.Message}}{{end}}]]>
+      </failure>{{end}}{{if $.CaptureOutput}}{{if $test.SystemOut}}      <system-out><![CDATA[{{$test.SystemOut}}]]></system-out>
+{{end}}{{if $test.SystemErr}}      <system-err><![CDATA[{{$test.SystemErr}}]]></system-err>
+{{end}}{{end}}    </testcase>
 {{end}}  </testsuite>
 {{end}}{{if .Multi}}</testsuites>{{end}}
 `))
 
+// subtestsMode controls how hierarchical subtests (created with t.Run) are
+// laid out in the rendered XML.
+type subtestsMode string
+
+const (
+	// subtestsGroup emits each subtest as its own <testcase>, sibling to
+	// its parent, with a classname of "{suite}.{parent}".
+	subtestsGroup subtestsMode = "group"
+	// subtestsFlatten drops subtests from the output entirely; only the
+	// parent test (with its duration already adjusted) is reported.
+	subtestsFlatten subtestsMode = "flatten"
+	// subtestsNested promotes each test with subtests into its own
+	// <testsuite>, nested alongside the package's own testsuite.
+	subtestsNested subtestsMode = "nested"
+)
+
+// groupChildren appends test, then recursively its subtests, to dst. Each
+// subtest is given a classname namespaced under its parent and a name
+// stripped down to its own "/"-separated segment.
+func groupChildren(test *Test, dst *Suite, classname string) {
+	test.Classname = classname
+	dst.Tests = append(dst.Tests, test)
+	for _, child := range test.Children {
+		if i := strings.LastIndex(child.Name, "/"); i >= 0 {
+			child.Name = child.Name[i+1:]
+		}
+		groupChildren(child, dst, classname+"."+test.Name)
+	}
+}
+
+// layoutSubtests rewrites suites, expanding or collapsing t.Run subtests
+// according to mode, and populates each rendered test's Classname.
+func layoutSubtests(suites []*Suite, mode subtestsMode) []*Suite {
+	out := make([]*Suite, 0, len(suites))
+	for _, suite := range suites {
+		flat := &Suite{Name: suite.Name, Time: suite.Time, Status: suite.Status, SystemOut: suite.SystemOut}
+		out = append(out, flat)
+
+		for _, test := range suite.Tests {
+			switch {
+			case mode == subtestsNested && len(test.Children) > 0:
+				nested := &Suite{Name: suite.Name + "." + test.Name}
+				groupChildren(test, nested, nested.Name)
+				out = append(out, nested)
+			case mode == subtestsFlatten:
+				test.Classname = suite.Name
+				flat.Tests = append(flat.Tests, test)
+			default: // subtestsGroup, or subtestsNested without children
+				groupChildren(test, flat, suite.Name)
+			}
+		}
+	}
+	return out
+}
+
 // writeXML exits xunit XML of tests to out
-func writeXML(suites []*Suite, out io.Writer, bamboo bool) error {
+func writeXML(suites []*Suite, out io.Writer, bamboo bool, mode subtestsMode, captureOutput bool) error {
+	suites = layoutSubtests(suites, mode)
 	testsResult := TestResults{
-		Suites: suites,
-		Multi:  bamboo || (len(suites) > 1),
+		Suites:        suites,
+		Multi:         bamboo || (len(suites) > 1),
+		CaptureOutput: captureOutput,
 	}
 	return xmlTemplate.Execute(out, testsResult)
 }
@@ -397,13 +1066,36 @@ func getIO(inputFile, outputFile string) (io.Reader, io.Writer, error) {
 	return input, output, nil
 }
 
+// looksLikeJSON peeks past leading whitespace to see if the input starts
+// with '{', the telltale sign of a "go test -json" event stream.
+func looksLikeJSON(br *bufio.Reader) bool {
+	for i := 1; ; i++ {
+		b, err := br.Peek(i)
+		if err != nil {
+			return false
+		}
+		switch c := b[len(b)-1]; c {
+		case ' ', '\t', '\r', '\n':
+			continue
+		default:
+			return c == '{'
+		}
+	}
+}
+
 func main() {
 	inputFile := flag.String("input", "", "input file (default to stdin)")
 	outputFile := flag.String("output", "", "output file (default to stdout)")
 	fail := flag.Bool("fail", false, "fail (non zero exit) if any test failed")
 	bamboo := flag.Bool("bamboo", false, "xml compatible with Atlassian's Bamboo")
 	gocheck := flag.Bool("gocheck", false, "parse gocheck output")
+	gojson := flag.Bool("gojson", false, "parse \"go test -json\" output")
 	race := flag.Bool("race", false, "mark tests with data races as failed")
+	subtests := flag.String("subtests", "group", "how to report t.Run subtests: group, flatten or nested")
+	bench := flag.Bool("bench", false, "parse bench-only output (use with `go test -bench` and `-run=^$`)")
+	buildFailuresAsTests := flag.Bool("build-failures-as-tests", false, "report a package build/setup failure as an errored testcase instead of aborting")
+	emitEmpty := flag.Bool("emit-empty", false, "report packages with no test files as an empty testsuite")
+	captureOutput := flag.Bool("capture-output", false, "emit <system-out>/<system-err> for every testcase and testsuite; without it, only a failing testcase's output is kept, inside its <failure>")
 	flag.Parse()
 
 	// No time ... prefix for error messages
@@ -418,12 +1110,21 @@ func main() {
 		log.Fatalf("error: %s", err)
 	}
 
-	parse := ParseGoTest
-	if *gocheck {
+	br := bufio.NewReader(input)
+
+	parse := func(rd io.Reader, race bool) ([]*Suite, error) {
+		return ParseGoTest(rd, race, *buildFailuresAsTests, *emitEmpty)
+	}
+	switch {
+	case *gocheck:
 		parse = ParseGoCheck
+	case *bench:
+		parse = ParseGoBench
+	case *gojson || looksLikeJSON(br):
+		parse = ParseGoTestJSON
 	}
 
-	suites, err := parse(input, *race)
+	suites, err := parse(br, *race)
 	if err != nil {
 		log.Fatalf("error: %s", err)
 	}
@@ -432,7 +1133,7 @@ func main() {
 		os.Exit(1)
 	}
 
-	err = writeXML(suites, output, *bamboo)
+	err = writeXML(suites, output, *bamboo, subtestsMode(*subtests), *captureOutput)
 	if err != nil {
 		log.Fatalln("error writing output:", err)
 	}
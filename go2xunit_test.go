@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestParseGoTestOrdinaryRun checks a single, non-subtest "=== RUN"/"---
+// PASS" pair with the three-space padding real "go test -v" output uses to
+// line the test name up under "--- PASS:"/"--- FAIL:".
+func TestParseGoTestOrdinaryRun(t *testing.T) {
+	input := "=== RUN   TestFoo\n--- PASS: TestFoo (0.00s)\nPASS\nok  \texample.com/pkg\t0.010s\n"
+
+	suites, err := ParseGoTest(strings.NewReader(input), false, false, false)
+	if err != nil {
+		t.Fatalf("ParseGoTest: %v", err)
+	}
+	if len(suites) != 1 || len(suites[0].Tests) != 1 {
+		t.Fatalf("expected 1 suite with 1 test, got %+v", suites)
+	}
+	if got := suites[0].Tests[0]; got.Name != "TestFoo" || got.Failed {
+		t.Errorf("got name=%q failed=%v, want name=TestFoo failed=false", got.Name, got.Failed)
+	}
+}
+
+// TestParseGoTestParallelSubtests checks that interleaved "=== RUN"/"---
+// PASS" lines from t.Parallel() subtests -- with "=== PAUSE"/"=== CONT"
+// lines in between that match no regex -- don't confuse the subtest stack.
+func TestParseGoTestParallelSubtests(t *testing.T) {
+	input := strings.Join([]string{
+		"=== RUN   TestFoo",
+		"=== RUN   TestFoo/A",
+		"=== PAUSE TestFoo/A",
+		"=== RUN   TestFoo/B",
+		"=== PAUSE TestFoo/B",
+		"=== CONT  TestFoo/A",
+		"--- PASS: TestFoo/A (0.00s)",
+		"=== CONT  TestFoo/B",
+		"--- PASS: TestFoo/B (0.00s)",
+		"--- PASS: TestFoo (0.00s)",
+		"PASS",
+		"ok  \texample.com/pkg\t0.010s",
+	}, "\n") + "\n"
+
+	suites, err := ParseGoTest(strings.NewReader(input), false, false, false)
+	if err != nil {
+		t.Fatalf("ParseGoTest: %v", err)
+	}
+	if len(suites) != 1 {
+		t.Fatalf("expected 1 suite, got %d", len(suites))
+	}
+	suite := suites[0]
+	if len(suite.Tests) != 1 {
+		t.Fatalf("expected 1 top-level test, got %d", len(suite.Tests))
+	}
+	foo := suite.Tests[0]
+	if foo.Name != "TestFoo" || foo.Failed {
+		t.Fatalf("TestFoo: got name=%q failed=%v, want name=TestFoo failed=false", foo.Name, foo.Failed)
+	}
+	if len(foo.Children) != 2 {
+		t.Fatalf("expected 2 subtests, got %d", len(foo.Children))
+	}
+	for _, child := range foo.Children {
+		if child.Failed {
+			t.Errorf("subtest %q: got failed=true, want false", child.Name)
+		}
+	}
+}
+
+// TestWriteXMLFailureBodyFallsBackToSystemOut checks that an ordinary
+// t.Error/t.Fatal failure, which never populates SystemErr, still gets its
+// full captured output in the <failure> CDATA body even with
+// -capture-output left at its default of false.
+func TestWriteXMLFailureBodyFallsBackToSystemOut(t *testing.T) {
+	input := strings.Join([]string{
+		"=== RUN   TestFoo",
+		"    foo_test.go:12: got 1, want 2",
+		"--- FAIL: TestFoo (0.00s)",
+		"FAIL",
+		"FAIL\texample.com/pkg\t0.010s",
+	}, "\n") + "\n"
+
+	suites, err := ParseGoTest(strings.NewReader(input), false, false, false)
+	if err != nil {
+		t.Fatalf("ParseGoTest: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeXML(suites, &buf, false, subtestsGroup, false); err != nil {
+		t.Fatalf("writeXML: %v", err)
+	}
+
+	if !strings.Contains(buf.String(), "foo_test.go:12: got 1, want 2") {
+		t.Errorf("failure CDATA missing full captured output, got:\n%s", buf.String())
+	}
+}
+
+// TestFindRaceLocationPrefersPreviousAccess checks that the location
+// reported for a data race is the historical "Previous write/read" access,
+// not the current access's block that always appears first in -race
+// output.
+func TestFindRaceLocationPrefersPreviousAccess(t *testing.T) {
+	lines := strings.Split(strings.TrimRight(`WARNING: DATA RACE
+Write at 0x00c0000140a0 by goroutine 8:
+  example.com/pkg.increment()
+      /src/pkg/current.go:10 +0x44
+
+Previous write at 0x00c0000140a0 by goroutine 7:
+  example.com/pkg.increment()
+      /src/pkg/previous.go:20 +0x44
+
+Goroutine 8 (running) created at:
+  example.com/pkg.TestRace()
+      /src/pkg/race_test.go:5 +0x30
+`, "\n"), "\n")
+
+	file, line, _, ok := findRaceLocation(lines)
+	if !ok {
+		t.Fatalf("findRaceLocation: not found")
+	}
+	if file != "previous.go" || line != "20" {
+		t.Errorf("got file=%q line=%q, want file=previous.go line=20", file, line)
+	}
+}
+
+// TestWriteXMLEscapesFailureMessage checks that a failure message
+// containing XML-special characters (as t.Errorf("%q") commonly produces)
+// is escaped in the rendered attribute rather than breaking the XML.
+func TestWriteXMLEscapesFailureMessage(t *testing.T) {
+	suites := []*Suite{{
+		Name: "example.com/pkg",
+		Tests: []*Test{{
+			Name:           "TestQuoting",
+			Failed:         true,
+			FailureMessage: `got "a" want "b" & <c>`,
+		}},
+	}}
+
+	var buf bytes.Buffer
+	if err := writeXML(suites, &buf, false, subtestsGroup, false); err != nil {
+		t.Fatalf("writeXML: %v", err)
+	}
+
+	if strings.Contains(buf.String(), `"got "a"`) {
+		t.Errorf("failure message attribute not escaped, got:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "&amp;") || !strings.Contains(buf.String(), "&lt;c&gt;") {
+		t.Errorf("failure message attribute missing expected escaping, got:\n%s", buf.String())
+	}
+}
+
+// TestParseGoTestInterleavedBenchFail checks that a benchmark failure
+// interleaved with regular test output (no "=== RUN"/"--- PASS" framing,
+// and no parenthesized timing for gtEndRE/gtBenchRE to match) is reported
+// as a failing testcase instead of silently dropped.
+func TestParseGoTestInterleavedBenchFail(t *testing.T) {
+	input := strings.Join([]string{
+		"=== RUN   TestFoo",
+		"--- PASS: TestFoo (0.00s)",
+		"BenchmarkBar-8   \t 1000000\t      1234 ns/op",
+		"--- FAIL: BenchmarkBaz-8",
+		"PASS",
+		"ok  \texample.com/pkg\t0.010s",
+	}, "\n") + "\n"
+
+	suites, err := ParseGoTest(strings.NewReader(input), false, false, false)
+	if err != nil {
+		t.Fatalf("ParseGoTest: %v", err)
+	}
+	if len(suites) != 1 || len(suites[0].Tests) != 3 {
+		t.Fatalf("expected 1 suite with 3 tests, got %+v", suites)
+	}
+
+	var baz *Test
+	for _, test := range suites[0].Tests {
+		if test.Name == "BenchmarkBaz" {
+			baz = test
+		}
+	}
+	if baz == nil {
+		t.Fatalf("BenchmarkBaz not found among tests: %+v", suites[0].Tests)
+	}
+	if !baz.Failed {
+		t.Errorf("BenchmarkBaz: got failed=false, want true")
+	}
+}
+
+// TestBuildFailedSuiteSkipsPkgBanner checks that the short error message
+// synthesized for a build failure is the compiler error itself, not the
+// "# pkg" banner line go test prints ahead of it.
+func TestBuildFailedSuiteSkipsPkgBanner(t *testing.T) {
+	out := []string{"# example.com/pkg", "foo.go:12:3: undefined: bar"}
+
+	suite := buildFailedSuite("example.com/pkg", out)
+	if len(suite.Tests) != 1 {
+		t.Fatalf("expected 1 test, got %d", len(suite.Tests))
+	}
+	got := suite.Tests[0].ErrorMessage
+	want := "foo.go:12:3: undefined: bar"
+	if got != want {
+		t.Errorf("ErrorMessage: got %q, want %q", got, want)
+	}
+}